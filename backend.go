@@ -0,0 +1,169 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"opsproxy/internal/fastcgi"
+)
+
+// BackendConfig is the config-file shape of a single upstream address within
+// a route's backend pool.
+type BackendConfig struct {
+	Addr string `yaml:"addr" json:"addr"`
+}
+
+// schemeHTTP and schemeFastCGI are the transports a route's backends can
+// speak. schemeHTTP (the default) proxies plain HTTP/HTTPS; schemeFastCGI
+// speaks the FastCGI protocol, for fronting PHP-FPM or similar application
+// servers directly.
+const (
+	schemeHTTP    = "http"
+	schemeFastCGI = "fastcgi"
+)
+
+// PassiveCheckConfig tunes passive health tracking: once consecutive failed
+// requests (dial errors or 5xx responses) reach FailureThreshold, the
+// backend is marked unhealthy for Cooldown before it is tried again.
+type PassiveCheckConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold" json:"failure_threshold"`
+	Cooldown         time.Duration `yaml:"cooldown" json:"cooldown"`
+}
+
+// Backend is one upstream in a route's pool, with atomically-updated health
+// state shared by the active checker, passive tracking, and the selection
+// policies.
+type Backend struct {
+	Addr string
+
+	targetURL *url.URL
+	proxy     *httputil.ReverseProxy
+	passive   *PassiveCheckConfig
+
+	consecFail       int32 // atomic
+	unhealthyUntilNS int64 // atomic, unix nanoseconds; 0 means healthy
+	activeConns      int32 // atomic, used by the LeastConn policy
+}
+
+// newBackend parses addr (a bare host:port or a full http(s):// URL) and
+// builds its reverse proxy, wiring passive failure tracking into the
+// proxy's ErrorHandler and ModifyResponse hooks. When scheme is
+// schemeFastCGI, the proxy's Transport speaks FastCGI to addr instead of
+// HTTP, using fastcgiRoot as the application's document root.
+func newBackend(addr string, passive *PassiveCheckConfig, scheme, fastcgiRoot string) (*Backend, error) {
+	target := addr
+	if !hasScheme(target) {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("backend %q: invalid address: %w", addr, err)
+	}
+
+	b := &Backend{Addr: addr, targetURL: u, passive: passive}
+	b.proxy = httputil.NewSingleHostReverseProxy(u)
+	if scheme == schemeFastCGI {
+		b.proxy.Transport = fastcgi.NewTransport("tcp", u.Host, fastcgiRoot)
+	}
+	b.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, e error) {
+		b.recordFailure()
+		log.Printf("ERROR: forwarding %s %s to backend %s: %v", r.Method, r.URL.String(), b.Addr, e)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	}
+	b.proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode >= 500 {
+			b.recordFailure()
+		} else {
+			b.recordSuccess()
+		}
+		return nil
+	}
+	return b, nil
+}
+
+func hasScheme(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ':':
+			return i > 0 && len(s) > i+2 && s[i+1] == '/' && s[i+2] == '/'
+		case '/':
+			return false
+		}
+	}
+	return false
+}
+
+// IsHealthy reports whether the backend should currently receive traffic.
+// Once a cooldown period elapses, the backend optimistically becomes
+// eligible again (it will be dropped immediately by the next failure).
+func (b *Backend) IsHealthy() bool {
+	until := atomic.LoadInt64(&b.unhealthyUntilNS)
+	if until == 0 {
+		return true
+	}
+	if time.Now().UnixNano() < until {
+		return false
+	}
+	// Cooldown elapsed: give the backend another chance.
+	atomic.StoreInt64(&b.unhealthyUntilNS, 0)
+	atomic.StoreInt32(&b.consecFail, 0)
+	return true
+}
+
+// markUnhealthy immediately marks the backend unhealthy for the given
+// cooldown, bypassing the passive failure-count threshold. Used by the
+// active health checker, which trusts a single failed probe.
+func (b *Backend) markUnhealthy(cooldown time.Duration) {
+	if cooldown <= 0 {
+		cooldown = 10 * time.Second
+	}
+	atomic.StoreInt64(&b.unhealthyUntilNS, time.Now().Add(cooldown).UnixNano())
+}
+
+// markHealthy clears any unhealthy state, used when an active check
+// succeeds.
+func (b *Backend) markHealthy() {
+	atomic.StoreInt64(&b.unhealthyUntilNS, 0)
+	atomic.StoreInt32(&b.consecFail, 0)
+}
+
+// recordFailure increments the consecutive-failure counter and, once it
+// reaches the configured threshold, marks the backend unhealthy.
+func (b *Backend) recordFailure() {
+	cfg := b.passive
+	if cfg == nil || cfg.FailureThreshold <= 0 {
+		return
+	}
+	n := atomic.AddInt32(&b.consecFail, 1)
+	if n >= int32(cfg.FailureThreshold) {
+		b.markUnhealthy(cfg.Cooldown)
+	}
+}
+
+// recordSuccess resets the consecutive-failure counter on a successful
+// request.
+func (b *Backend) recordSuccess() {
+	atomic.StoreInt32(&b.consecFail, 0)
+}
@@ -0,0 +1,251 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// defaultSkipContentTypePrefixes are response Content-Types that gain little
+// or nothing from compression because they're already compressed (images,
+// video, archives).
+var defaultSkipContentTypePrefixes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/x-bzip2",
+}
+
+// compressConfig configures the compression middleware.
+type compressConfig struct {
+	Algorithms       []string // in preference order, e.g. ["br", "gzip"]
+	MinBytes         int
+	SkipContentTypes []string // additional Content-Type prefixes to never compress
+}
+
+func (cfg *compressConfig) skip(contentType string) bool {
+	ct := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	for _, prefix := range defaultSkipContentTypePrefixes {
+		if strings.HasPrefix(ct, prefix) {
+			return true
+		}
+	}
+	for _, prefix := range cfg.SkipContentTypes {
+		if strings.HasPrefix(ct, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate picks the first of cfg.Algorithms the client's Accept-Encoding
+// header accepts.
+func (cfg *compressConfig) negotiate(r *http.Request) string {
+	accept := strings.ToLower(r.Header.Get("Accept-Encoding"))
+	for _, alg := range cfg.Algorithms {
+		for _, part := range strings.Split(accept, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			if strings.TrimSpace(name) != alg {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+					continue // explicitly refused
+				}
+			}
+			return alg
+		}
+	}
+	return ""
+}
+
+// compressionMiddleware wraps next so its responses are transparently
+// gzip/br-compressed when the client supports it, skipping compression
+// when the backend already encoded the body, the request was a protocol
+// upgrade, the Content-Type isn't worth compressing, or the body is smaller
+// than cfg.MinBytes. cfg may be nil, in which case next is returned as-is.
+func compressionMiddleware(next http.Handler, cfg *compressConfig) http.Handler {
+	if cfg == nil || len(cfg.Algorithms) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUpgradeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressResponseWriter{ResponseWriter: w, req: r, cfg: cfg, statusCode: http.StatusOK}
+		defer cw.finish()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressResponseWriter buffers the start of a response so it can sniff
+// Content-Type and measure size before deciding whether to compress, then
+// transparently switches subsequent writes through a gzip/br encoder (or
+// passes them straight through once the decision is "don't compress").
+type compressResponseWriter struct {
+	http.ResponseWriter
+	req *http.Request
+	cfg *compressConfig
+
+	statusCode int
+	buf        bytes.Buffer
+
+	decided bool
+	enc     string // "identity", "gzip", or "br" once decided
+	gz      *gzip.Writer
+	br      *brotli.Writer
+}
+
+func (cw *compressResponseWriter) WriteHeader(code int) {
+	cw.statusCode = code
+}
+
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		return cw.writeEncoded(p)
+	}
+	n, _ := cw.buf.Write(p)
+	if cw.buf.Len() >= cw.cfg.MinBytes {
+		cw.decide()
+	}
+	return n, nil
+}
+
+// decide picks identity/gzip/br based on the response so far and the
+// negotiated encoding, sends the real status line and headers, and flushes
+// the buffered prefix through whichever path was chosen.
+func (cw *compressResponseWriter) decide() {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+
+	header := cw.ResponseWriter.Header()
+	ct := header.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(cw.buf.Bytes())
+		header.Set("Content-Type", ct)
+	}
+
+	alreadyEncoded := header.Get("Content-Encoding") != ""
+	tooSmall := cw.buf.Len() < cw.cfg.MinBytes
+
+	cw.enc = "identity"
+	if !alreadyEncoded && !tooSmall && !cw.cfg.skip(ct) {
+		if alg := cw.cfg.negotiate(cw.req); alg != "" {
+			cw.enc = alg
+		}
+	}
+
+	if cw.enc != "identity" {
+		header.Set("Content-Encoding", cw.enc)
+		header.Del("Content-Length") // length changes once compressed
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+
+	switch cw.enc {
+	case "gzip":
+		cw.gz = gzip.NewWriter(cw.ResponseWriter)
+		_, _ = cw.gz.Write(cw.buf.Bytes())
+	case "br":
+		cw.br = brotli.NewWriter(cw.ResponseWriter)
+		_, _ = cw.br.Write(cw.buf.Bytes())
+	default:
+		_, _ = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+}
+
+func (cw *compressResponseWriter) writeEncoded(p []byte) (int, error) {
+	switch cw.enc {
+	case "gzip":
+		return cw.gz.Write(p)
+	case "br":
+		return cw.br.Write(p)
+	default:
+		return cw.ResponseWriter.Write(p)
+	}
+}
+
+// finish flushes any buffered prefix that never crossed MinBytes and closes
+// the active encoder. It must run after the handler returns.
+func (cw *compressResponseWriter) finish() {
+	cw.decide()
+	if cw.gz != nil {
+		_ = cw.gz.Close()
+	}
+	if cw.br != nil {
+		_ = cw.br.Close()
+	}
+}
+
+// Flush implements http.Flusher, flushing any active encoder before
+// flushing the underlying writer so streaming responses still work.
+func (cw *compressResponseWriter) Flush() {
+	cw.decide()
+	if cw.gz != nil {
+		_ = cw.gz.Flush()
+	}
+	if cw.br != nil {
+		_ = cw.br.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker so upgrade requests that somehow reach
+// this writer (isUpgradeRequest should normally route around it) still
+// work rather than failing closed.
+func (cw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+	}
+	return hj.Hijack()
+}
+
+// parseCompressFlag turns a "-compress=gzip,br" flag value into the
+// algorithm preference list, lowercased and with unknown names dropped.
+func parseCompressFlag(v string) []string {
+	var algs []string
+	for _, part := range strings.Split(v, ",") {
+		alg := strings.ToLower(strings.TrimSpace(part))
+		if alg == "gzip" || alg == "br" {
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
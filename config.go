@@ -0,0 +1,65 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the top-level shape of the `-config` file: a flat list of
+// routes, plus an optional address for the raw SNI dispatcher.
+type Config struct {
+	SNIListen string   `yaml:"sni_listen" json:"sni_listen"`
+	Routes    []*Route `yaml:"routes" json:"routes"`
+}
+
+// LoadConfig reads and parses a routing config file. The format is chosen by
+// file extension: .yaml/.yml is parsed as YAML, anything else (including
+// .json) is parsed as JSON.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	var cfg Config
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing config %s as YAML: %w", path, err)
+		}
+	} else {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing config %s as JSON: %w", path, err)
+		}
+	}
+
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("config %s: no routes defined", path)
+	}
+	for _, rt := range cfg.Routes {
+		if rt.Target == "" && len(rt.Backends) == 0 {
+			return nil, fmt.Errorf("config %s: route %q has no target or backends", path, rt.Name)
+		}
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,143 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// hopByHopHeaders are stripped before forwarding a request or response, per
+// RFC 7230 section 6.1 — they describe this hop's connection, not the
+// resource, and must not be passed through a proxy unchanged.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func stripHopByHop(h http.Header) {
+	for _, k := range hopByHopHeaders {
+		h.Del(k)
+	}
+}
+
+// runForwardProxy starts opsproxy in `-mode forward`: a classic forward HTTP
+// proxy handling plain absolute-URI requests and CONNECT tunneling. When
+// mitmCache is non-nil, CONNECT additionally terminates TLS toward the
+// client with a dynamically-generated leaf certificate so HTTPS traffic can
+// be inspected before being re-encrypted to the real origin.
+func runForwardProxy(listenPort int, mitmCache *certCache) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			handleConnect(w, r, mitmCache)
+			return
+		}
+		handleForward(w, r)
+	})
+
+	addr := fmt.Sprintf(":%d", listenPort)
+	log.Printf("Forward proxy listening on %s (mitm=%v)", addr, mitmCache != nil)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Fatalf("server failed: %v", err)
+	}
+}
+
+// handleForward proxies a plain (non-CONNECT) forward-proxy request: the
+// request line carries an absolute URI, so r.URL already names the real
+// destination.
+func handleForward(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.IsAbs() {
+		http.Error(w, "forward proxy requires an absolute-URI request target", http.StatusBadRequest)
+		return
+	}
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	stripHopByHop(outReq.Header)
+
+	resp, err := http.DefaultTransport.RoundTrip(outReq)
+	if err != nil {
+		log.Printf("ERROR: forward proxy %s %s: %v", r.Method, r.URL.String(), err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	stripHopByHop(resp.Header)
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// handleConnect implements HTTP CONNECT tunneling: it hijacks the client
+// connection, dials the requested target, and either splices raw bytes
+// (plain tunnel mode) or — when mitmCache is set — terminates TLS toward
+// the client and re-encrypts toward the origin so requests can be read and
+// forwarded one at a time.
+func handleConnect(w http.ResponseWriter, r *http.Request, mitmCache *certCache) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hj.Hijack()
+	if err != nil {
+		log.Printf("ERROR: CONNECT %s: hijack failed: %v", r.Host, err)
+		return
+	}
+	defer clientConn.Close()
+
+	if mitmCache == nil {
+		targetConn, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			log.Printf("ERROR: CONNECT %s: dial failed: %v", r.Host, err)
+			_, _ = clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+			return
+		}
+		defer targetConn.Close()
+
+		if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+			log.Printf("ERROR: CONNECT %s: writing 200: %v", r.Host, err)
+			return
+		}
+		_ = rawSplice(clientConn, targetConn, clientBuf.Reader, bufio.NewReader(targetConn))
+		return
+	}
+
+	// mitmServeTLS dials the origin itself, so no targetConn is opened here.
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		log.Printf("ERROR: CONNECT %s: writing 200: %v", r.Host, err)
+		return
+	}
+	mitmServeTLS(clientConn, clientBuf.Reader, r.Host, mitmCache)
+}
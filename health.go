@@ -0,0 +1,119 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// ActiveCheckConfig tunes the periodic HTTP probe opsproxy runs against each
+// backend in a route's pool, independent of passive failure tracking.
+type ActiveCheckConfig struct {
+	Path           string        `yaml:"path" json:"path"`
+	ExpectedStatus int           `yaml:"expected_status" json:"expected_status"`
+	ExpectedBody   string        `yaml:"expected_body" json:"expected_body"` // regexp, optional
+	Interval       time.Duration `yaml:"interval" json:"interval"`
+	Timeout        time.Duration `yaml:"timeout" json:"timeout"`
+	Cooldown       time.Duration `yaml:"cooldown" json:"cooldown"`
+
+	bodyRE *regexp.Regexp
+}
+
+func (cfg *ActiveCheckConfig) build() error {
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.ExpectedStatus == 0 {
+		cfg.ExpectedStatus = http.StatusOK
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.ExpectedBody != "" {
+		re, err := regexp.Compile(cfg.ExpectedBody)
+		if err != nil {
+			return err
+		}
+		cfg.bodyRE = re
+	}
+	return nil
+}
+
+// startHealthChecks launches one ticking goroutine per backend in the
+// route's pool. It is a no-op when the route has no active check
+// configured.
+func (rt *Route) startHealthChecks(stop <-chan struct{}) {
+	if rt.HealthCheck == nil {
+		return
+	}
+	for _, b := range rt.backends {
+		go runActiveCheck(b, rt.HealthCheck, stop)
+	}
+}
+
+func runActiveCheck(b *Backend, cfg *ActiveCheckConfig, stop <-chan struct{}) {
+	client := &http.Client{Timeout: cfg.Timeout}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			checkOnce(client, b, cfg)
+		}
+	}
+}
+
+func checkOnce(client *http.Client, b *Backend, cfg *ActiveCheckConfig) {
+	u := *b.targetURL
+	u.Path = cfg.Path
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		log.Printf("ERROR: health check %s: %v", b.Addr, err)
+		b.markUnhealthy(cfg.Cooldown)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != cfg.ExpectedStatus {
+		log.Printf("ERROR: health check %s: status %d, want %d", b.Addr, resp.StatusCode, cfg.ExpectedStatus)
+		b.markUnhealthy(cfg.Cooldown)
+		return
+	}
+
+	if cfg.bodyRE != nil {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || !cfg.bodyRE.Match(body) {
+			log.Printf("ERROR: health check %s: body did not match %q", b.Addr, cfg.ExpectedBody)
+			b.markUnhealthy(cfg.Cooldown)
+			return
+		}
+	}
+
+	b.markHealthy()
+}
@@ -0,0 +1,86 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fastcgi
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// buildParams derives the CGI-style environment variables (FastCGI spec
+// section 6.2) a RESPONDER needs from req: the standard request metadata,
+// plus one HTTP_* variable per request header.
+//
+// It errors if req.URL.Path resolves (after joining onto Root and cleaning
+// "." / ".." segments) to a path outside Root, rather than handing the
+// FastCGI application a SCRIPT_FILENAME it never should have seen.
+func (t *Transport) buildParams(req *http.Request) (map[string]string, error) {
+	remoteAddr, remotePort := splitHostPort(req.RemoteAddr)
+
+	scriptName := req.URL.Path
+	scriptFilename := path.Join(path.Clean(t.Root), scriptName)
+	// Cleaning the path relative to "." leaves a leading ".." exactly when it
+	// climbs above its starting point, whatever Root is (including "/" or "").
+	if rel := path.Clean("./" + scriptName); rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, fmt.Errorf("request path %q escapes document root %q", scriptName, t.Root)
+	}
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "opsproxy",
+		"SERVER_PROTOCOL":   req.Proto,
+		"SERVER_NAME":       req.Host,
+		"REQUEST_METHOD":    req.Method,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"QUERY_STRING":      req.URL.RawQuery,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   scriptFilename,
+		"DOCUMENT_ROOT":     t.Root,
+		"REMOTE_ADDR":       remoteAddr,
+		"REMOTE_PORT":       remotePort,
+	}
+
+	if req.ContentLength > 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		params["CONTENT_TYPE"] = ct
+	}
+
+	for name, values := range req.Header {
+		if name == "Content-Type" || name == "Content-Length" {
+			continue // already carried as CONTENT_TYPE/CONTENT_LENGTH
+		}
+		key := "HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		params[key] = strings.Join(values, ", ")
+	}
+
+	return params, nil
+}
+
+// splitHostPort splits "host:port" into its parts, tolerating a missing
+// port (returns it as "").
+func splitHostPort(hostport string) (host, port string) {
+	if i := strings.LastIndex(hostport, ":"); i >= 0 {
+		return hostport[:i], hostport[i+1:]
+	}
+	return hostport, ""
+}
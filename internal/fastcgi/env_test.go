@@ -0,0 +1,56 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fastcgi
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildParamsRejectsPathTraversal(t *testing.T) {
+	tr := &Transport{Root: "/var/www"}
+
+	cases := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"root script", "/index.php", false},
+		{"nested script", "/app/index.php", false},
+		{"traversal above root", "/../../../../etc/passwd", true},
+		{"traversal collapses through root", "/../../../etc/passwd", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.URL.Path = tc.path
+
+			params, err := tr.buildParams(req)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("buildParams(%q): expected error, got SCRIPT_FILENAME=%q", tc.path, params["SCRIPT_FILENAME"])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildParams(%q): unexpected error: %v", tc.path, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fastcgi
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// defaultMaxIdle bounds how many idle connections a connPool keeps per
+// backend address.
+const defaultMaxIdle = 8
+
+// connPool is a small per-address pool of persistent FastCGI connections.
+// A connection is taken out of the pool for the duration of one request and
+// returned afterward (or closed, if the request left it in a bad state).
+type connPool struct {
+	network string
+	address string
+
+	mu      sync.Mutex
+	idle    []net.Conn
+	maxIdle int
+}
+
+func newConnPool(network, address string) *connPool {
+	return &connPool{network: network, address: address, maxIdle: defaultMaxIdle}
+}
+
+// get returns an idle connection if one is available, otherwise dials a new
+// one.
+func (p *connPool) get() (net.Conn, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return conn, nil
+	}
+	p.mu.Unlock()
+
+	conn, err := net.Dial(p.network, p.address)
+	if err != nil {
+		return nil, fmt.Errorf("fastcgi: dial %s %s: %w", p.network, p.address, err)
+	}
+	return conn, nil
+}
+
+// put returns a connection to the pool for reuse, closing it instead if the
+// pool is already full.
+func (p *connPool) put(conn net.Conn) {
+	p.mu.Lock()
+	if len(p.idle) >= p.maxIdle {
+		p.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
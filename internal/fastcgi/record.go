@@ -0,0 +1,171 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package fastcgi implements a minimal FastCGI client transport, so opsproxy
+// can front PHP-FPM or Python FastCGI ops dashboards without terminating an
+// HTTP connection to them. It implements only what's needed to play the
+// RESPONDER role against a single backend address: BEGIN_REQUEST, PARAMS,
+// STDIN, and reading STDOUT/STDERR back until END_REQUEST. See the FastCGI
+// spec at https://fastcgi-archives.github.io/FastCGI_Specification.html.
+package fastcgi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Record types, FastCGI spec section 3.3.
+const (
+	typeBeginRequest = 1
+	typeAbortRequest = 2
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+)
+
+// Roles, FastCGI spec section 3.2.
+const roleResponder = 1
+
+const (
+	version1      = 1
+	maxRecordBody = 65535
+	headerLen     = 8
+)
+
+// header is the 8-byte FastCGI record header.
+type header struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func readHeader(r io.Reader) (header, error) {
+	var buf [headerLen]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return header{}, err
+	}
+	return header{
+		Version:       buf[0],
+		Type:          buf[1],
+		RequestID:     binary.BigEndian.Uint16(buf[2:4]),
+		ContentLength: binary.BigEndian.Uint16(buf[4:6]),
+		PaddingLength: buf[6],
+		Reserved:      buf[7],
+	}, nil
+}
+
+func (h header) write(w io.Writer) error {
+	var buf [headerLen]byte
+	buf[0] = h.Version
+	buf[1] = h.Type
+	binary.BigEndian.PutUint16(buf[2:4], h.RequestID)
+	binary.BigEndian.PutUint16(buf[4:6], h.ContentLength)
+	buf[6] = h.PaddingLength
+	buf[7] = h.Reserved
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// writeRecord writes content as one or more records of the given type,
+// splitting it into chunks no larger than maxRecordBody as the spec
+// requires. An empty content slice still writes a single zero-length
+// record, which FastCGI uses as a stream terminator (e.g. empty PARAMS or
+// STDIN).
+func writeRecord(w io.Writer, recType uint8, reqID uint16, content []byte) error {
+	if len(content) == 0 {
+		return header{Version: version1, Type: recType, RequestID: reqID}.write(w)
+	}
+	for len(content) > 0 {
+		n := len(content)
+		if n > maxRecordBody {
+			n = maxRecordBody
+		}
+		chunk := content[:n]
+		content = content[n:]
+
+		h := header{Version: version1, Type: recType, RequestID: reqID, ContentLength: uint16(n)}
+		if err := h.write(w); err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBeginRequest writes a BEGIN_REQUEST record for the RESPONDER role
+// with the FCGI_KEEP_CONN flag set, so the application leaves the
+// connection open after END_REQUEST and Transport can return it to the
+// pool for the next request.
+func writeBeginRequest(w io.Writer, reqID uint16) error {
+	body := [8]byte{}
+	binary.BigEndian.PutUint16(body[0:2], roleResponder)
+	body[2] = 1 // flags: FCGI_KEEP_CONN
+	h := header{Version: version1, Type: typeBeginRequest, RequestID: reqID, ContentLength: uint16(len(body))}
+	if err := h.write(w); err != nil {
+		return err
+	}
+	_, err := w.Write(body[:])
+	return err
+}
+
+// endRequest is the parsed body of an END_REQUEST record.
+type endRequest struct {
+	AppStatus      uint32
+	ProtocolStatus uint8
+}
+
+func parseEndRequest(body []byte) (endRequest, error) {
+	if len(body) < 8 {
+		return endRequest{}, fmt.Errorf("fastcgi: short END_REQUEST body (%d bytes)", len(body))
+	}
+	return endRequest{
+		AppStatus:      binary.BigEndian.Uint32(body[0:4]),
+		ProtocolStatus: body[4],
+	}, nil
+}
+
+// encodeParams encodes a set of name/value pairs using the FastCGI
+// name-value length encoding (spec section 3.4): each length is a single
+// byte if <128, or a 4-byte big-endian length with the high bit set
+// otherwise.
+func encodeParams(params map[string]string) []byte {
+	var out []byte
+	for k, v := range params {
+		out = appendLen(out, len(k))
+		out = appendLen(out, len(v))
+		out = append(out, k...)
+		out = append(out, v...)
+	}
+	return out
+}
+
+func appendLen(out []byte, n int) []byte {
+	if n < 128 {
+		return append(out, byte(n))
+	}
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(n)|0x80000000)
+	return append(out, buf[:]...)
+}
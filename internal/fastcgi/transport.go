@@ -0,0 +1,233 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// fixedRequestID is used for every request: each request gets its own
+// connection from the pool, so FastCGI request multiplexing is never
+// needed.
+const fixedRequestID = 1
+
+// Transport implements http.RoundTripper by speaking FastCGI to a single
+// backend address, so the standard library's httputil.ReverseProxy (and its
+// ErrorHandler, header rewriting, etc.) can front a FastCGI application
+// (e.g. PHP-FPM) the same way it fronts an HTTP backend.
+type Transport struct {
+	// Network and Address identify the backend, e.g. ("tcp", "127.0.0.1:9000")
+	// or ("unix", "/run/php-fpm.sock").
+	Network string
+	Address string
+
+	// Root is used to build SCRIPT_FILENAME/DOCUMENT_ROOT; it should be the
+	// document root the FastCGI application expects paths relative to.
+	Root string
+
+	pool *connPool
+}
+
+// NewTransport builds a Transport pooling connections to address over
+// network ("tcp" or "unix").
+func NewTransport(network, address, root string) *Transport {
+	return &Transport{
+		Network: network,
+		Address: address,
+		Root:    root,
+		pool:    newConnPool(network, address),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := t.pool.get()
+	if err != nil {
+		return nil, err
+	}
+
+	ok := false
+	defer func() {
+		if ok {
+			t.pool.put(conn)
+		} else {
+			_ = conn.Close()
+		}
+	}()
+
+	params, err := t.buildParams(req)
+	if err != nil {
+		ok = true // conn was never written to; still reusable
+		return errorResponse(req, http.StatusBadRequest, err.Error()), nil
+	}
+
+	if err := writeBeginRequest(conn, fixedRequestID); err != nil {
+		return nil, fmt.Errorf("fastcgi: writing BEGIN_REQUEST: %w", err)
+	}
+
+	if err := writeRecord(conn, typeParams, fixedRequestID, encodeParams(params)); err != nil {
+		return nil, fmt.Errorf("fastcgi: writing PARAMS: %w", err)
+	}
+	if err := writeRecord(conn, typeParams, fixedRequestID, nil); err != nil {
+		return nil, fmt.Errorf("fastcgi: terminating PARAMS: %w", err)
+	}
+
+	if err := t.streamStdin(conn, req); err != nil {
+		return nil, fmt.Errorf("fastcgi: writing STDIN: %w", err)
+	}
+
+	resp, err := t.readResponse(conn, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ok = true
+	return resp, nil
+}
+
+// streamStdin copies the request body to the backend as STDIN records,
+// terminated by an empty STDIN record.
+func (t *Transport) streamStdin(conn io.Writer, req *http.Request) error {
+	if req.Body != nil {
+		buf := make([]byte, maxRecordBody)
+		for {
+			n, err := req.Body.Read(buf)
+			if n > 0 {
+				if werr := writeRecord(conn, typeStdin, fixedRequestID, buf[:n]); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writeRecord(conn, typeStdin, fixedRequestID, nil)
+}
+
+// readResponse reads STDOUT/STDERR records until END_REQUEST, logs any
+// STDERR output, and parses the accumulated STDOUT as a CGI response
+// (headers, optionally an initial "Status:" line, then the body).
+func (t *Transport) readResponse(conn io.Reader, req *http.Request) (*http.Response, error) {
+	var stdout, stderr bytes.Buffer
+
+	for {
+		h, err := readHeader(conn)
+		if err != nil {
+			return nil, fmt.Errorf("fastcgi: reading record header: %w", err)
+		}
+
+		body := make([]byte, h.ContentLength)
+		if h.ContentLength > 0 {
+			if _, err := io.ReadFull(conn, body); err != nil {
+				return nil, fmt.Errorf("fastcgi: reading record body: %w", err)
+			}
+		}
+		if h.PaddingLength > 0 {
+			if _, err := io.CopyN(io.Discard, conn, int64(h.PaddingLength)); err != nil {
+				return nil, fmt.Errorf("fastcgi: reading record padding: %w", err)
+			}
+		}
+
+		switch h.Type {
+		case typeStdout:
+			stdout.Write(body)
+		case typeStderr:
+			stderr.Write(body)
+		case typeEndRequest:
+			end, err := parseEndRequest(body)
+			if err != nil {
+				return nil, err
+			}
+			if stderr.Len() > 0 {
+				log.Printf("ERROR: fastcgi %s: %s", t.Address, strings.TrimRight(stderr.String(), "\n"))
+			}
+			if end.ProtocolStatus != 0 {
+				return nil, fmt.Errorf("fastcgi: protocol status %d (app status %d)", end.ProtocolStatus, end.AppStatus)
+			}
+			return parseCGIResponse(stdout.Bytes(), req)
+		}
+	}
+}
+
+// errorResponse builds a canned *http.Response for request-level failures
+// (e.g. a rejected SCRIPT_FILENAME) that never reach the FastCGI backend, so
+// Transport can report them without the ReverseProxy's generic 502.
+func errorResponse(req *http.Request, status int, msg string) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(msg)),
+		Request:    req,
+	}
+}
+
+// parseCGIResponse parses a CGI-style response (RFC 3875 section 6): MIME
+// headers up to a blank line, an optional "Status:" header overriding the
+// default 200, then the body.
+func parseCGIResponse(raw []byte, req *http.Request) (*http.Response, error) {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("fastcgi: parsing CGI response headers: %w", err)
+	}
+
+	status := http.StatusOK
+	if s := mimeHeader.Get("Status"); s != "" {
+		// textproto.Reader only trims leading/trailing ASCII space and tab,
+		// while strings.Fields splits on all Unicode whitespace; a backend
+		// that sends e.g. "Status:  \r\n" leaves s non-empty but
+		// strings.Fields(s) empty, so the length must be checked before
+		// indexing.
+		if fields := strings.Fields(s); len(fields) > 0 {
+			if code, convErr := strconv.Atoi(fields[0]); convErr == nil {
+				status = code
+			}
+		}
+		mimeHeader.Del("Status")
+	}
+
+	header := http.Header(mimeHeader)
+	body := io.NopCloser(tp.R)
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       body,
+		Request:    req,
+	}, nil
+}
@@ -0,0 +1,41 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fastcgi
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestParseCGIResponseNonASCIIWhitespaceStatus guards against a panic: a
+// "Status" header made up entirely of non-ASCII whitespace (U+00A0 NBSP)
+// survives textproto.Reader's ASCII-only trimming as a non-empty string,
+// but strings.Fields then splits it into zero fields, so indexing [0]
+// without a length check panicked the handling goroutine.
+func TestParseCGIResponseNonASCIIWhitespaceStatus(t *testing.T) {
+	raw := []byte("Status:  \r\nContent-Type: text/plain\r\n\r\nbody")
+	req := httptest.NewRequest("GET", "/", nil)
+
+	resp, err := parseCGIResponse(raw, req)
+	if err != nil {
+		t.Fatalf("parseCGIResponse: unexpected error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("StatusCode = %d, want 200 (unparsable Status should fall back to the default)", resp.StatusCode)
+	}
+}
@@ -18,6 +18,7 @@
 package main
 
 import (
+	"bufio"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -29,6 +30,8 @@ import (
 	"net/url"
 	"strings"
 	"sync/atomic"
+
+	"opsproxy/internal/fastcgi"
 )
 
 var firstLogged int32
@@ -38,8 +41,30 @@ func main() {
 	targetHost := flag.String("target-host", "127.0.0.1", "target host (may include scheme)")
 	targetPort := flag.Int("target-port", 8080, "target port")
 	logLevel := flag.String("log-level", "info", "log level: verbose|info|error")
+	configPath := flag.String("config", "", "path to a YAML/JSON router config (multi-backend routing by Host/path/SNI); overrides -target-host/-target-port")
+	targetScheme := flag.String("target-scheme", schemeHTTP, "backend transport: http|fastcgi")
+	fastcgiRoot := flag.String("fastcgi-root", "", "document root for FastCGI env vars (SCRIPT_FILENAME/DOCUMENT_ROOT); only used when -target-scheme=fastcgi")
+	mode := flag.String("mode", "reverse", "reverse (default) or forward: run as a forward HTTP/CONNECT proxy instead of a reverse proxy")
+	mitm := flag.Bool("mitm", false, "in -mode forward, terminate and re-encrypt CONNECT tunnels using -mitm-ca-cert/-mitm-ca-key to inspect HTTPS traffic")
+	mitmCACert := flag.String("mitm-ca-cert", "", "PEM CA certificate used to sign generated leaf certs when -mitm is set")
+	mitmCAKey := flag.String("mitm-ca-key", "", "PEM CA private key used to sign generated leaf certs when -mitm is set")
+	mitmInsecureSkipVerify := flag.Bool("mitm-insecure-skip-verify", false, "in -mitm mode, skip verifying the origin server's certificate on the upstream leg (dangerous; for inspecting self-signed test backends only)")
+	compress := flag.String("compress", "", "comma-separated response compressors to opt into, e.g. gzip,br (disabled by default)")
+	minCompressBytes := flag.Int("min-compress-bytes", 256, "skip compression for responses smaller than this many bytes")
 	flag.Parse()
 
+	compressCfg := &compressConfig{Algorithms: parseCompressFlag(*compress), MinBytes: *minCompressBytes}
+
+	if *mode == "forward" {
+		runForward(*listenPort, *mitm, *mitmCACert, *mitmCAKey, *mitmInsecureSkipVerify)
+		return
+	}
+
+	if *configPath != "" {
+		runWithConfig(*configPath, *listenPort, compressCfg)
+		return
+	}
+
 	// Build target URL. If user provided a scheme in targetHost, respect it.
 	var target string
 	if strings.HasPrefix(*targetHost, "http://") || strings.HasPrefix(*targetHost, "https://") {
@@ -59,6 +84,9 @@ func main() {
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(u)
+	if *targetScheme == schemeFastCGI {
+		proxy.Transport = fastcgi.NewTransport("tcp", u.Host, *fastcgiRoot)
+	}
 
 	// ErrorHandler logs errors and returns 502
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, e error) {
@@ -99,7 +127,8 @@ func main() {
 		proxy.ServeHTTP(w, r)
 	})
 
-	http.Handle("/", handler)
+	http.HandleFunc("/-/ws-metrics", serveWSMetrics)
+	http.Handle("/", compressionMiddleware(handler, compressCfg))
 
 	addr := fmt.Sprintf(":%d", *listenPort)
 	log.Printf("Proxy listening on %s -> %s (log level=%s)", addr, u.String(), *logLevel)
@@ -109,6 +138,56 @@ func main() {
 	}
 }
 
+// runWithConfig starts opsproxy in multi-backend mode: routes are selected
+// by Host header and URL path prefix per the loaded config, and (when
+// SNIListen is set) a raw TLS SNI dispatcher forwards un-terminated
+// connections to the matching backend on a separate listener. compressCfg,
+// when it has at least one algorithm, wraps every response in the
+// compression middleware.
+func runWithConfig(configPath string, listenPort int, compressCfg *compressConfig) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+
+	router, err := NewRouter(cfg.Routes, make(chan struct{}))
+	if err != nil {
+		log.Fatalf("building router: %v", err)
+	}
+
+	if cfg.SNIListen != "" {
+		go func() {
+			if err := router.ListenAndServeSNI(cfg.SNIListen); err != nil {
+				log.Fatalf("sni dispatcher: %v", err)
+			}
+		}()
+	}
+
+	addr := fmt.Sprintf(":%d", listenPort)
+	log.Printf("Proxy listening on %s (config=%s, %d routes)", addr, configPath, len(cfg.Routes))
+
+	if err := http.ListenAndServe(addr, compressionMiddleware(router, compressCfg)); err != nil {
+		log.Fatalf("server failed: %v", err)
+	}
+}
+
+// runForward starts opsproxy in `-mode forward`, handling plain absolute-URI
+// requests and CONNECT tunneling. When mitm is true it additionally
+// terminates and re-encrypts CONNECT tunnels using the given CA so the
+// HTTPS requests and responses inside them reach mitmHooks.
+func runForward(listenPort int, mitm bool, caCertPath, caKeyPath string, originInsecureSkipVerify bool) {
+	var cache *certCache
+	if mitm {
+		ca, err := loadCA(caCertPath, caKeyPath)
+		if err != nil {
+			log.Fatalf("loading MITM CA: %v", err)
+		}
+		cache = newCertCache(ca, 1024)
+		cache.originInsecureSkipVerify = originInsecureSkipVerify
+	}
+	runForwardProxy(listenPort, cache)
+}
+
 // isUpgradeRequest returns true if the incoming request asks to upgrade the
 // connection (commonly used for WebSockets).
 func isUpgradeRequest(r *http.Request) bool {
@@ -123,10 +202,42 @@ func isUpgradeRequest(r *http.Request) bool {
 	return r.Header.Get("Upgrade") != ""
 }
 
-// proxyUpgrade performs a raw TCP proxy between the client and the backend
-// for upgrade requests. It dials the backend using the scheme/host from u
-// and forwards bytes in both directions.
+// dialUpgradeBackend opens the backend connection for an upgrade request at
+// u, without touching the client connection. Splitting the dial out from
+// proxyUpgradeConn lets callers that can retry (picking a different backend
+// on failure) do so before anything irreversible happens to the client side:
+// once the client connection is hijacked there is no way to "un-commit" to
+// it, so only the dial itself is safe to retry.
+func dialUpgradeBackend(u *url.URL) (net.Conn, error) {
+	backendAddr := u.Host
+	if u.Scheme == "https" {
+		return tls.Dial("tcp", backendAddr, &tls.Config{InsecureSkipVerify: true})
+	}
+	return net.Dial("tcp", backendAddr)
+}
+
+// proxyUpgrade dials the backend at u and proxies the upgrade request to it.
+// It is the single-target entry point kept for the legacy (configless)
+// reverse-proxy mode, where there is only ever one backend to try.
 func proxyUpgrade(w http.ResponseWriter, r *http.Request, u *url.URL) error {
+	backendConn, err := dialUpgradeBackend(u)
+	if err != nil {
+		return fmt.Errorf("dial backend %s: %w", u.Host, err)
+	}
+	return proxyUpgradeConn(w, r, backendConn)
+}
+
+// proxyUpgradeConn proxies an upgrade request over an already-dialed
+// backendConn. It re-serializes the original request to the backend and the
+// backend's handshake response to the client, then — once the backend actually switches
+// protocols — relays WebSocket frames individually via pumpWSFrames so each
+// message can pass through the registered hooks and be counted in
+// wsMetrics. Non-101 responses (the upgrade was refused, or this wasn't
+// really a WebSocket) fall back to a raw byte splice, same as before frame
+// awareness was added.
+func proxyUpgradeConn(w http.ResponseWriter, r *http.Request, backendConn net.Conn) error {
+	defer func() { _ = backendConn.Close() }()
+
 	// Hijack client connection
 	hj, ok := w.(http.Hijacker)
 	if !ok {
@@ -140,37 +251,58 @@ func proxyUpgrade(w http.ResponseWriter, r *http.Request, u *url.URL) error {
 		_ = clientConn.Close()
 	}()
 
-	// Connect to backend
-	backendAddr := u.Host
-	// Ensure host has a port if missing (Url.Parse guarantees when scheme present)
-	var backendConn net.Conn
-	if u.Scheme == "https" {
-		backendConn, err = tls.Dial("tcp", backendAddr, &tls.Config{InsecureSkipVerify: true})
-	} else {
-		backendConn, err = net.Dial("tcp", backendAddr)
+	// Write the request line and headers to the backend (preserve original)
+	if err := r.Write(backendConn); err != nil {
+		return fmt.Errorf("writing request to backend: %w", err)
 	}
+
+	backendBuf := bufio.NewReader(backendConn)
+	resp, err := http.ReadResponse(backendBuf, r)
 	if err != nil {
-		return fmt.Errorf("dial backend %s: %w", backendAddr, err)
+		return fmt.Errorf("reading backend handshake response: %w", err)
+	}
+	if err := resp.Write(clientConn); err != nil {
+		return fmt.Errorf("writing handshake response to client: %w", err)
 	}
-	defer func() { _ = backendConn.Close() }()
 
-	// Write the request line and headers to the backend (preserve original)
-	if err := r.Write(backendConn); err != nil {
-		return fmt.Errorf("writing request to backend: %w", err)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// Not actually a protocol switch (e.g. the backend rejected the
+		// upgrade); fall back to a raw splice of whatever comes next.
+		return rawSplice(clientConn, backendConn, clientBuf.Reader, backendBuf)
 	}
 
-	// Now proxy bytes between clientConn and backendConn
+	// permessage-deflate is negotiated entirely by the handshake headers we
+	// already forwarded untouched above; we only track whether it ended up
+	// active, since compressed frames still parse as opaque binary payloads.
+	compressed := strings.Contains(strings.ToLower(resp.Header.Get("Sec-WebSocket-Extensions")), "permessage-deflate")
+	log.Printf("WS: upgraded %s %s <- %s (permessage-deflate=%v)", r.Method, r.URL.String(), r.RemoteAddr, compressed)
+
+	wsMetrics.incOpen()
+	defer wsMetrics.decOpen()
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- pumpWSFrames(clientBuf.Reader, backendConn, wsHooks.OnClientMessage)
+	}()
+	go func() {
+		errc <- pumpWSFrames(backendBuf, clientConn, wsHooks.OnBackendMessage)
+	}()
+
+	return <-errc
+}
+
+// rawSplice copies bytes in both directions without any framing awareness,
+// draining each side's already-buffered bytes first. It is the fallback
+// used when a hijacked upgrade attempt didn't actually switch protocols.
+func rawSplice(clientConn, backendConn net.Conn, clientBuf, backendBuf *bufio.Reader) error {
 	errc := make(chan error, 2)
 	go func() {
 		_, e := io.Copy(backendConn, clientBuf)
 		errc <- e
 	}()
 	go func() {
-		_, e := io.Copy(clientConn, backendConn)
+		_, e := io.Copy(clientConn, backendBuf)
 		errc <- e
 	}()
-
-	// Wait for one side to finish or error
-	e := <-errc
-	return e
+	return <-errc
 }
@@ -0,0 +1,262 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MITMHooks lets callers observe requests and responses flowing through a
+// -mitm CONNECT tunnel after TLS has been terminated and before it is
+// re-encrypted toward the origin.
+type MITMHooks struct {
+	OnRequest  func(*http.Request)
+	OnResponse func(*http.Response)
+}
+
+var mitmHooks = &MITMHooks{}
+
+// RegisterMITMHooks installs the given hooks for all future MITM-terminated
+// HTTPS connections.
+func RegisterMITMHooks(hooks *MITMHooks) {
+	if hooks == nil {
+		hooks = &MITMHooks{}
+	}
+	mitmHooks = hooks
+}
+
+// certCache is an LRU cache of leaf certificates generated on demand for
+// each SNI a MITM client connects for, so the (relatively expensive) key
+// generation and signing only happens once per distinct host.
+type certCache struct {
+	ca *tls.Certificate
+
+	// originInsecureSkipVerify controls whether mitmServeTLS verifies the
+	// real origin's certificate on the upstream leg. It defaults to false
+	// (verify); set true only to inspect traffic to self-signed test
+	// backends.
+	originInsecureSkipVerify bool
+
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type certCacheEntry struct {
+	sni  string
+	cert *tls.Certificate
+}
+
+// newCertCache builds a cache backed by ca, holding at most capacity leaf
+// certificates before evicting the least recently used.
+func newCertCache(ca *tls.Certificate, capacity int) *certCache {
+	return &certCache{
+		ca:       ca,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// getOrCreate returns the cached leaf certificate for sni, generating and
+// signing a new one if this is the first time it's been seen.
+func (c *certCache) getOrCreate(sni string) (*tls.Certificate, error) {
+	c.mu.Lock()
+	if elem, ok := c.items[sni]; ok {
+		c.ll.MoveToFront(elem)
+		cert := elem.Value.(*certCacheEntry).cert
+		c.mu.Unlock()
+		return cert, nil
+	}
+	c.mu.Unlock()
+
+	cert, err := generateLeafCert(c.ca, sni)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Another goroutine may have raced us to generate the same sni; prefer
+	// whichever is already cached rather than holding two leaf certs.
+	if elem, ok := c.items[sni]; ok {
+		c.ll.MoveToFront(elem)
+		return elem.Value.(*certCacheEntry).cert, nil
+	}
+	elem := c.ll.PushFront(&certCacheEntry{sni: sni, cert: cert})
+	c.items[sni] = elem
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*certCacheEntry).sni)
+		}
+	}
+	return cert, nil
+}
+
+// loadCA reads a PEM certificate/key pair to use as the MITM certificate
+// authority; the key signs every dynamically-generated leaf certificate.
+func loadCA(certPath, keyPath string) (*tls.Certificate, error) {
+	ca, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading MITM CA from %s/%s: %w", certPath, keyPath, err)
+	}
+	leaf, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing MITM CA certificate: %w", err)
+	}
+	ca.Leaf = leaf
+	return &ca, nil
+}
+
+// generateLeafCert mints a leaf certificate for sni, valid for one year and
+// signed by ca, so a TLS client that trusts ca will accept it for that host.
+func generateLeafCert(ca *tls.Certificate, sni string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating leaf key for %q: %w", sni, err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial number for %q: %w", sni, err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: sni},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if sni != "" {
+		template.DNSNames = []string{sni}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Leaf, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing leaf certificate for %q: %w", sni, err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.Certificate[0]},
+		PrivateKey:  key,
+	}, nil
+}
+
+// mitmServeTLS terminates TLS on clientConn using a leaf certificate minted
+// for whatever SNI the client requests (falling back to the CONNECT target
+// host), dials the real origin over TLS, and relays HTTP requests/responses
+// one at a time between them, invoking mitmHooks on each.
+func mitmServeTLS(clientConn net.Conn, buffered *bufio.Reader, targetHostPort string, cache *certCache) {
+	tlsConn := tls.Server(readerConn{Conn: clientConn, r: buffered}, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			sni := hello.ServerName
+			if sni == "" {
+				sni = hostOnly(targetHostPort)
+			}
+			return cache.getOrCreate(sni)
+		},
+	})
+	defer tlsConn.Close()
+
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("ERROR: mitm: TLS handshake with client for %s: %v", targetHostPort, err)
+		return
+	}
+
+	originConn, err := tls.Dial("tcp", targetHostPort, &tls.Config{InsecureSkipVerify: cache.originInsecureSkipVerify})
+	if err != nil {
+		log.Printf("ERROR: mitm: dialing origin %s: %v", targetHostPort, err)
+		return
+	}
+	defer originConn.Close()
+
+	clientReader := bufio.NewReader(tlsConn)
+	originReader := bufio.NewReader(originConn)
+
+	for {
+		req, err := http.ReadRequest(clientReader)
+		if err != nil {
+			return // client closed the connection or sent garbage; tunnel is done
+		}
+		req.URL.Scheme = "https"
+		req.URL.Host = targetHostPort
+		if mitmHooks.OnRequest != nil {
+			mitmHooks.OnRequest(req)
+		}
+
+		if err := req.Write(originConn); err != nil {
+			log.Printf("ERROR: mitm: writing request to origin %s: %v", targetHostPort, err)
+			return
+		}
+
+		resp, err := http.ReadResponse(originReader, req)
+		if err != nil {
+			log.Printf("ERROR: mitm: reading response from origin %s: %v", targetHostPort, err)
+			return
+		}
+		if mitmHooks.OnResponse != nil {
+			mitmHooks.OnResponse(resp)
+		}
+
+		if err := resp.Write(tlsConn); err != nil {
+			log.Printf("ERROR: mitm: writing response to client for %s: %v", targetHostPort, err)
+			_ = resp.Body.Close()
+			return
+		}
+		_ = resp.Body.Close()
+	}
+}
+
+// hostOnly strips a trailing ":port" from a CONNECT target.
+func hostOnly(hostport string) string {
+	if i := strings.LastIndex(hostport, ":"); i >= 0 {
+		return hostport[:i]
+	}
+	return hostport
+}
+
+// readerConn adapts a net.Conn whose initial bytes have already been
+// buffered (by the http.Server that hijacked it) so tls.Server sees the
+// full byte stream, not just whatever hasn't been read into the buffer
+// yet.
+type readerConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c readerConn) Read(p []byte) (int, error) { return c.r.Read(p) }
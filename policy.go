@@ -0,0 +1,138 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+)
+
+// SelectionPolicy picks one backend from a route's pool for a given
+// request. Implementations should only return unhealthy backends when no
+// healthy one is available, so a single route never goes fully dark just
+// because every backend has tripped its passive check at once.
+type SelectionPolicy interface {
+	Pick(backends []*Backend, r *http.Request) *Backend
+}
+
+// candidates returns the healthy subset of backends, falling back to the
+// full pool (fail-open) when none are currently healthy.
+func candidates(backends []*Backend) []*Backend {
+	healthy := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		if b.IsHealthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		return backends
+	}
+	return healthy
+}
+
+// NewSelectionPolicy builds the named policy. Unknown names fall back to
+// RoundRobin.
+func NewSelectionPolicy(name string) SelectionPolicy {
+	switch name {
+	case "random":
+		return &RandomPolicy{}
+	case "least_conn":
+		return &LeastConnPolicy{}
+	case "ip_hash":
+		return &IPHashPolicy{}
+	case "round_robin", "":
+		return &RoundRobinPolicy{}
+	default:
+		return &RoundRobinPolicy{}
+	}
+}
+
+// RoundRobinPolicy cycles through candidates in order.
+type RoundRobinPolicy struct {
+	counter uint32 // atomic
+}
+
+func (p *RoundRobinPolicy) Pick(backends []*Backend, r *http.Request) *Backend {
+	c := candidates(backends)
+	if len(c) == 0 {
+		return nil
+	}
+	n := atomic.AddUint32(&p.counter, 1)
+	return c[int(n-1)%len(c)]
+}
+
+// RandomPolicy picks a uniformly random candidate.
+type RandomPolicy struct{}
+
+func (p *RandomPolicy) Pick(backends []*Backend, r *http.Request) *Backend {
+	c := candidates(backends)
+	if len(c) == 0 {
+		return nil
+	}
+	return c[rand.Intn(len(c))]
+}
+
+// LeastConnPolicy picks the candidate with the fewest in-flight requests.
+type LeastConnPolicy struct{}
+
+func (p *LeastConnPolicy) Pick(backends []*Backend, r *http.Request) *Backend {
+	c := candidates(backends)
+	if len(c) == 0 {
+		return nil
+	}
+	best := c[0]
+	for _, b := range c[1:] {
+		if atomic.LoadInt32(&b.activeConns) < atomic.LoadInt32(&best.activeConns) {
+			best = b
+		}
+	}
+	return best
+}
+
+// IPHashPolicy deterministically maps a client IP to a candidate, so a given
+// client sticks to the same backend as long as the set of healthy
+// candidates does not change.
+type IPHashPolicy struct{}
+
+func (p *IPHashPolicy) Pick(backends []*Backend, r *http.Request) *Backend {
+	c := candidates(backends)
+	if len(c) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientIP(r)))
+	return c[int(h.Sum32())%len(c)]
+}
+
+// clientIP extracts the client address from a request, stripping any port.
+// r may be nil (e.g. the raw SNI dispatcher has no *http.Request), in which
+// case an empty key is used.
+func clientIP(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	addr := r.RemoteAddr
+	for i := len(addr) - 1; i >= 0; i-- {
+		if addr[i] == ':' {
+			return addr[:i]
+		}
+	}
+	return addr
+}
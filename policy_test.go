@@ -0,0 +1,68 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCandidatesFailover(t *testing.T) {
+	healthy := &Backend{Addr: "healthy"}
+	unhealthy := &Backend{Addr: "unhealthy"}
+	unhealthy.markUnhealthy(time.Minute)
+
+	cases := []struct {
+		name     string
+		backends []*Backend
+		want     []*Backend
+	}{
+		{"mixed pool returns only healthy", []*Backend{healthy, unhealthy}, []*Backend{healthy}},
+		{"all unhealthy fails open to full pool", []*Backend{unhealthy}, []*Backend{unhealthy}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := candidates(tc.backends)
+			if len(got) != len(tc.want) {
+				t.Fatalf("candidates() = %v, want %v", got, tc.want)
+			}
+			for i, b := range got {
+				if b != tc.want[i] {
+					t.Fatalf("candidates()[%d] = %v, want %v", i, b, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRoundRobinPolicySkipsUnhealthy(t *testing.T) {
+	healthy := &Backend{Addr: "healthy"}
+	unhealthy := &Backend{Addr: "unhealthy"}
+	unhealthy.markUnhealthy(time.Minute)
+
+	p := &RoundRobinPolicy{}
+	backends := []*Backend{healthy, unhealthy}
+
+	for i := 0; i < 4; i++ {
+		got := p.Pick(backends, nil)
+		if got != healthy {
+			t.Fatalf("Pick() = %v, want the only healthy backend %v", got, healthy)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Route describes an upstream backend pool and the rules used to select the
+// route. A Route matches a request when its Host (if set) equals the
+// request's Host header and its PathPrefix (if set) is a prefix of the
+// request path. When fronting opsproxy on 443, SNI additionally allows the
+// raw TCP dispatcher to pick this route before TLS is terminated.
+//
+// Target is a back-compat shorthand for a single-backend route; Backends
+// lists a full pool for load balancing and health checking. At least one of
+// the two must be set.
+type Route struct {
+	Name        string              `yaml:"name" json:"name"`
+	Host        string              `yaml:"host" json:"host"`
+	PathPrefix  string              `yaml:"path_prefix" json:"path_prefix"`
+	SNI         string              `yaml:"sni" json:"sni"`
+	Target      string              `yaml:"target" json:"target"`
+	Backends    []*BackendConfig    `yaml:"backends" json:"backends"`
+	Policy      string              `yaml:"policy" json:"policy"` // round_robin|random|least_conn|ip_hash
+	HealthCheck *ActiveCheckConfig  `yaml:"health_check" json:"health_check"`
+	Passive     *PassiveCheckConfig `yaml:"passive_check" json:"passive_check"`
+	Scheme      string              `yaml:"scheme" json:"scheme"`             // http (default) | fastcgi
+	FastCGIRoot string              `yaml:"fastcgi_root" json:"fastcgi_root"` // document root, fastcgi scheme only
+
+	backends []*Backend
+	policy   SelectionPolicy
+}
+
+// build resolves the route's backend pool into *Backend reverse proxies,
+// compiles the active health check (if any), and constructs the selection
+// policy. It is called once after the config is loaded.
+func (rt *Route) build() error {
+	addrs := rt.backendAddrs()
+	if len(addrs) == 0 {
+		return fmt.Errorf("route %q: no backends configured", rt.Name)
+	}
+
+	for _, addr := range addrs {
+		b, err := newBackend(addr, rt.Passive, rt.Scheme, rt.FastCGIRoot)
+		if err != nil {
+			return fmt.Errorf("route %q: %w", rt.Name, err)
+		}
+		rt.backends = append(rt.backends, b)
+	}
+
+	if rt.HealthCheck != nil {
+		if err := rt.HealthCheck.build(); err != nil {
+			return fmt.Errorf("route %q: invalid health_check: %w", rt.Name, err)
+		}
+	}
+
+	rt.policy = NewSelectionPolicy(rt.Policy)
+	return nil
+}
+
+// backendAddrs returns the configured backend addresses, falling back to
+// the single-backend Target shorthand.
+func (rt *Route) backendAddrs() []string {
+	if len(rt.Backends) > 0 {
+		addrs := make([]string, len(rt.Backends))
+		for i, b := range rt.Backends {
+			addrs[i] = b.Addr
+		}
+		return addrs
+	}
+	if rt.Target != "" {
+		return []string{rt.Target}
+	}
+	return nil
+}
+
+// pick selects a backend for r using the route's configured policy.
+func (rt *Route) pick(r *http.Request) *Backend {
+	return rt.policy.Pick(rt.backends, r)
+}
+
+// pickExcluding is like pick, but skips any backend already in exclude. It
+// backs the per-request retry loop in Router.ServeHTTP/handleSNIConn: each
+// failed attempt is added to exclude so the next pick lands on a different
+// candidate instead of repeating the same dead one.
+func (rt *Route) pickExcluding(r *http.Request, exclude map[*Backend]bool) *Backend {
+	if len(exclude) == 0 {
+		return rt.pick(r)
+	}
+	remaining := make([]*Backend, 0, len(rt.backends))
+	for _, b := range rt.backends {
+		if !exclude[b] {
+			remaining = append(remaining, b)
+		}
+	}
+	if len(remaining) == 0 {
+		return nil
+	}
+	return rt.policy.Pick(remaining, r)
+}
+
+// matches reports whether the route applies to the given host and path. An
+// empty Host or PathPrefix is treated as a wildcard for that dimension.
+func (rt *Route) matches(host, path string) bool {
+	if rt.Host != "" && !hostMatches(rt.Host, host) {
+		return false
+	}
+	if rt.PathPrefix != "" && !strings.HasPrefix(path, rt.PathPrefix) {
+		return false
+	}
+	return true
+}
+
+// hostMatches compares a route's configured host pattern against the
+// request Host header, ignoring a trailing port on the request side and
+// supporting a single leading "*." wildcard label.
+func hostMatches(pattern, host string) bool {
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	if pattern == host {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:] // ".example.com"
+		return strings.HasSuffix(host, suffix) && host != suffix[1:]
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Router holds the set of configured routes and selects one per request (or
+// per raw TLS connection, via SNI). Routes are matched in configuration
+// order; the first match wins.
+type Router struct {
+	Routes []*Route
+}
+
+// NewRouter builds a Router from config-loaded routes, resolving each
+// route's backend pool into reverse proxies and starting any configured
+// active health checks. stop, when closed, halts the health checkers.
+func NewRouter(routes []*Route, stop <-chan struct{}) (*Router, error) {
+	for _, rt := range routes {
+		if err := rt.build(); err != nil {
+			return nil, err
+		}
+		rt.startHealthChecks(stop)
+	}
+	return &Router{Routes: routes}, nil
+}
+
+// Match returns the first Route whose Host/PathPrefix rules match r, or nil
+// if none do.
+func (rtr *Router) Match(r *http.Request) *Route {
+	for _, rt := range rtr.Routes {
+		if rt.matches(r.Host, r.URL.Path) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// matchSNI returns the first Route whose SNI pattern matches the given
+// ClientHello server name.
+func (rtr *Router) matchSNI(serverName string) *Route {
+	for _, rt := range rtr.Routes {
+		if rt.SNI != "" && hostMatches(rt.SNI, serverName) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler, dispatching to a healthy backend of the
+// matched route, or a 404 when no route matches. The admin health endpoint
+// is served regardless of the matched route.
+func (rtr *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/-/health":
+		rtr.serveHealth(w, r)
+		return
+	case "/-/ws-metrics":
+		serveWSMetrics(w, r)
+		return
+	}
+
+	rt := rtr.Match(r)
+	if rt == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if isUpgradeRequest(r) {
+		rtr.serveUpgrade(w, r, rt)
+		return
+	}
+
+	rtr.serveProxy(w, r, rt)
+}
+
+// serveUpgrade dials a backend for an upgrade request and, like
+// serveProxy, retries the next candidate on dial failure before giving up —
+// a request that never reached a backend hasn't committed the client
+// connection yet, so it's still safe to try elsewhere. Once a dial
+// succeeds, proxyUpgradeConn hijacks the client connection and the attempt
+// is no longer retryable: any failure past that point is final.
+func (rtr *Router) serveUpgrade(w http.ResponseWriter, r *http.Request, rt *Route) {
+	tried := make(map[*Backend]bool, len(rt.backends))
+	for attempt := 0; attempt < len(rt.backends); attempt++ {
+		b := rt.pickExcluding(r, tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+
+		backendConn, err := dialUpgradeBackend(b.targetURL)
+		if err != nil {
+			b.recordFailure()
+			log.Printf("ERROR: upgrade dial backend %s for %s %s: %v", b.Addr, r.Method, r.URL.String(), err)
+			continue
+		}
+
+		if err := proxyUpgradeConn(w, r, backendConn); err != nil {
+			log.Printf("ERROR: upgrade proxy %s %s from %s: %v", r.Method, r.URL.String(), r.RemoteAddr, err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		}
+		return
+	}
+	http.Error(w, "Bad Gateway", http.StatusBadGateway)
+}
+
+// serveProxy dispatches a plain (non-upgrade) request to a backend of rt.
+// Requests with a body get exactly one attempt via the backend's
+// httputil.ReverseProxy, same as before: retrying would mean buffering the
+// body so it could be replayed, and an attacker-controlled body is exactly
+// the kind of unbounded buffering opsproxy otherwise goes out of its way to
+// avoid. Bodyless requests (most GETs, health checks, etc.) cost nothing to
+// replay, so those retry across the route's other backends on a dial error
+// or 5xx response before failing, the same way the selection policy already
+// steers around backends known to be unhealthy.
+func (rtr *Router) serveProxy(w http.ResponseWriter, r *http.Request, rt *Route) {
+	if r.ContentLength != 0 {
+		b := rt.pick(r)
+		if b == nil {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		atomic.AddInt32(&b.activeConns, 1)
+		defer atomic.AddInt32(&b.activeConns, -1)
+		b.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	tried := make(map[*Backend]bool, len(rt.backends))
+	var lastErr error
+	for attempt := 0; attempt < len(rt.backends); attempt++ {
+		b := rt.pickExcluding(r, tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+
+		atomic.AddInt32(&b.activeConns, 1)
+		resp, err := roundTripBackend(b, r)
+		atomic.AddInt32(&b.activeConns, -1)
+		if err != nil {
+			b.recordFailure()
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			b.recordFailure()
+			resp.Body.Close()
+			lastErr = fmt.Errorf("backend %s: %s", b.Addr, resp.Status)
+			continue
+		}
+
+		b.recordSuccess()
+		writeProxyResponse(w, resp)
+		return
+	}
+
+	log.Printf("ERROR: forwarding %s %s from %s: all backends failed: %v", r.Method, r.URL.String(), r.RemoteAddr, lastErr)
+	http.Error(w, "Bad Gateway", http.StatusBadGateway)
+}
+
+// roundTripBackend rewrites r the same way b's httputil.ReverseProxy would
+// (via its Director) and sends it straight through the proxy's Transport,
+// bypassing ServeHTTP so the caller can inspect the response — and retry
+// against a different backend on failure — before anything is written to
+// the real ResponseWriter.
+func roundTripBackend(b *Backend, r *http.Request) (*http.Response, error) {
+	outReq := r.Clone(r.Context())
+	b.proxy.Director(outReq)
+	transport := b.proxy.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(outReq)
+}
+
+// writeProxyResponse commits a successful backend response to w: hop-by-hop
+// headers are stripped (as httputil.ReverseProxy.ServeHTTP would do) before
+// copying the rest across, mirroring the response side of handleForward.
+func writeProxyResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	stripHopByHop(resp.Header)
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// backendHealth is the JSON shape returned by GET /-/health.
+type backendHealth struct {
+	Route   string `json:"route"`
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+}
+
+// serveHealth reports the current health of every backend across all
+// routes.
+func (rtr *Router) serveHealth(w http.ResponseWriter, r *http.Request) {
+	var out []backendHealth
+	for _, rt := range rtr.Routes {
+		for _, b := range rt.backends {
+			out = append(out, backendHealth{Route: rt.Name, Addr: b.Addr, Healthy: b.IsHealthy()})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// ListenAndServeSNI accepts raw TCP connections on addr, peeks the TLS
+// ClientHello to extract the SNI server name, and forwards the raw
+// connection byte-for-byte to the matching route's backend without
+// terminating TLS. It is intended for fronting opsproxy on 443 when TLS
+// should be passed through untouched to the upstream.
+func (rtr *Router) ListenAndServeSNI(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("sni listen %s: %w", addr, err)
+	}
+	defer ln.Close()
+
+	log.Printf("SNI dispatcher listening on %s", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("sni accept: %w", err)
+		}
+		go rtr.handleSNIConn(conn)
+	}
+}
+
+func (rtr *Router) handleSNIConn(clientConn net.Conn) {
+	defer clientConn.Close()
+
+	br := bufio.NewReader(clientConn)
+	serverName, err := peekClientHelloServerName(br)
+	if err != nil {
+		log.Printf("ERROR: sni: reading ClientHello from %s: %v", clientConn.RemoteAddr(), err)
+		return
+	}
+
+	rt := rtr.matchSNI(serverName)
+	if rt == nil {
+		log.Printf("ERROR: sni: no route for server name %q from %s", serverName, clientConn.RemoteAddr())
+		return
+	}
+
+	backendConn := rtr.dialSNIBackend(rt, serverName)
+	if backendConn == nil {
+		log.Printf("ERROR: sni: no backend available for %q", serverName)
+		return
+	}
+	defer backendConn.Close()
+
+	errc := make(chan error, 2)
+	go func() {
+		_, e := io.Copy(backendConn, br)
+		errc <- e
+	}()
+	go func() {
+		_, e := io.Copy(clientConn, backendConn)
+		errc <- e
+	}()
+	<-errc
+}
+
+// dialSNIBackend picks a backend for serverName from rt and dials it,
+// retrying the next candidate if the dial fails — nothing has been written
+// to clientConn yet at this point, so a dead backend here is exactly as
+// safe to route around as one caught by the selection policy's health
+// filtering. Returns nil once every candidate has failed to dial.
+func (rtr *Router) dialSNIBackend(rt *Route, serverName string) net.Conn {
+	tried := make(map[*Backend]bool, len(rt.backends))
+	for attempt := 0; attempt < len(rt.backends); attempt++ {
+		b := rt.pickExcluding(nil, tried)
+		if b == nil {
+			return nil
+		}
+		tried[b] = true
+
+		backendConn, err := net.Dial("tcp", b.targetURL.Host)
+		if err != nil {
+			b.recordFailure()
+			log.Printf("ERROR: sni: dialing backend %s for %q: %v", b.targetURL.Host, serverName, err)
+			continue
+		}
+		b.recordSuccess()
+		return backendConn
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// recordHeaderLen is the size of a TLS record header: content type (1),
+// protocol version (2) and record length (2).
+const recordHeaderLen = 5
+
+// peekClientHelloServerName extracts the SNI server name from the TLS
+// ClientHello at the front of br, using br.Peek so no bytes are consumed
+// from the underlying connection: the same bytes are forwarded byte-for-byte
+// by the raw splice in handleSNIConn afterward.
+//
+// It peeks the record header to learn the handshake record's length, peeks
+// that whole record, and runs a one-shot tls.Server handshake over a private
+// copy of those bytes purely to parse the ClientHello, discarding the
+// resulting (failed) handshake. This only sees a ClientHello sent as a
+// single TLS record, which covers every client in practice.
+func peekClientHelloServerName(br *bufio.Reader) (string, error) {
+	hdr, err := br.Peek(recordHeaderLen)
+	if err != nil {
+		return "", fmt.Errorf("peeking record header: %w", err)
+	}
+	if hdr[0] != 0x16 {
+		return "", fmt.Errorf("not a TLS handshake record (type %d)", hdr[0])
+	}
+	recLen := int(hdr[3])<<8 | int(hdr[4])
+
+	peeked, err := br.Peek(recordHeaderLen + recLen)
+	if err != nil {
+		return "", fmt.Errorf("peeking ClientHello record: %w", err)
+	}
+	// Copy out of the peeked bytes: tls.Server retains no backreference to
+	// br's internal buffer, which br is free to overwrite on its next fill.
+	buf := make([]byte, len(peeked))
+	copy(buf, peeked)
+
+	var serverName string
+	sawHello := false
+
+	conn := &helloSniffConn{r: bytes.NewReader(buf)}
+	cfg := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			sawHello = true
+			return nil, fmt.Errorf("opsproxy: sni peek only, aborting handshake")
+		},
+	}
+	_ = tls.Server(conn, cfg).Handshake()
+
+	if !sawHello {
+		return "", fmt.Errorf("no ClientHello received")
+	}
+	return serverName, nil
+}
+
+// helloSniffConn adapts an io.Reader to the minimal net.Conn surface
+// required to drive a one-shot tls.Server handshake far enough to observe
+// the ClientHello. Writes are discarded; the handshake is expected to abort
+// once GetConfigForClient returns an error.
+type helloSniffConn struct {
+	r io.Reader
+}
+
+func (c *helloSniffConn) Read(p []byte) (int, error)       { return c.r.Read(p) }
+func (c *helloSniffConn) Write(p []byte) (int, error)      { return len(p), nil }
+func (c *helloSniffConn) Close() error                     { return nil }
+func (c *helloSniffConn) LocalAddr() net.Addr              { return sniffAddr{} }
+func (c *helloSniffConn) RemoteAddr() net.Addr             { return sniffAddr{} }
+func (c *helloSniffConn) SetDeadline(time.Time) error      { return nil }
+func (c *helloSniffConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *helloSniffConn) SetWriteDeadline(time.Time) error { return nil }
+
+// sniffAddr is a throwaway net.Addr for helloSniffConn, which has no real
+// network endpoint.
+type sniffAddr struct{}
+
+func (sniffAddr) Network() string { return "sniff" }
+func (sniffAddr) String() string  { return "sniff" }
@@ -0,0 +1,80 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestPeekClientHelloServerNamePreservesBytes drives a real TLS client
+// handshake over a net.Pipe so a genuine ClientHello record reaches
+// peekClientHelloServerName, then asserts the bytes are still sitting in
+// the bufio.Reader afterward (the bug this guards against silently
+// swallowed the ClientHello, breaking every connection through the raw SNI
+// dispatcher).
+func TestPeekClientHelloServerNamePreservesBytes(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	go func() {
+		// The client never receives a ServerHello, so this handshake never
+		// completes; we only care that it writes a ClientHello.
+		cfg := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true}
+		_ = tls.Client(clientConn, cfg).Handshake()
+	}()
+
+	br := bufio.NewReader(serverConn)
+
+	type result struct {
+		serverName string
+		err        error
+	}
+	done := make(chan result, 1)
+	go func() {
+		name, err := peekClientHelloServerName(br)
+		done <- result{name, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("peekClientHelloServerName: %v", r.err)
+		}
+		if r.serverName != "example.com" {
+			t.Fatalf("serverName = %q, want %q", r.serverName, "example.com")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peekClientHelloServerName timed out")
+	}
+
+	// If the handshake consumed the ClientHello instead of peeking it, br
+	// would have nothing left to give: the record's first byte (0x16,
+	// handshake content type) must still be readable.
+	first, err := br.Peek(1)
+	if err != nil {
+		t.Fatalf("br.Peek after parse: %v (ClientHello bytes were consumed)", err)
+	}
+	if first[0] != 0x16 {
+		t.Fatalf("first remaining byte = %#x, want 0x16 (ClientHello bytes were consumed)", first[0])
+	}
+}
@@ -0,0 +1,303 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// WebSocket opcodes, per RFC 6455 section 5.2.
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// maxWSFrameLength bounds a single frame's declared payload length. Without
+// it, a 16/64-bit extended length read straight off the wire would be
+// handed directly to make([]byte, length), letting either side of the
+// proxied connection force a multi-gigabyte allocation with one frame
+// header. 32MiB comfortably covers real messages while still being cheap
+// to reject.
+const maxWSFrameLength = 32 << 20
+
+// maxWSMessageLength bounds the total size of a text/binary message
+// reassembled across continuation frames. maxWSFrameLength alone only caps
+// one frame's allocation; an endpoint can still FIN-delay indefinitely,
+// sending an unbounded number of continuation frames and growing msgBuf
+// without limit. 64MiB covers real multi-frame messages with room to spare.
+const maxWSMessageLength = 64 << 20
+
+// WSHooks lets callers observe reassembled WebSocket messages as they pass
+// through the proxy, without being able to alter what is forwarded: frames
+// are relayed verbatim regardless of what a hook does.
+type WSHooks struct {
+	OnClientMessage  func(msgType int, payload []byte)
+	OnBackendMessage func(msgType int, payload []byte)
+}
+
+// wsHooks holds the hooks registered via RegisterWebSocketHooks. nil fields
+// are no-ops.
+var wsHooks = &WSHooks{}
+
+// RegisterWebSocketHooks installs the given hooks for all future WebSocket
+// connections proxied by this process.
+func RegisterWebSocketHooks(hooks *WSHooks) {
+	if hooks == nil {
+		hooks = &WSHooks{}
+	}
+	wsHooks = hooks
+}
+
+// WSMetrics tracks aggregate WebSocket traffic across all proxied
+// connections.
+type WSMetrics struct {
+	Frames     int64 // atomic
+	Bytes      int64 // atomic
+	OpenConns  int32 // atomic
+	closeCodes struct {
+		mu sync.Mutex
+		m  map[int]int64
+	}
+}
+
+// wsMetrics is the process-wide WebSocket metrics instance, served at
+// /-/ws-metrics.
+var wsMetrics = newWSMetrics()
+
+func newWSMetrics() *WSMetrics {
+	m := &WSMetrics{}
+	m.closeCodes.m = make(map[int]int64)
+	return m
+}
+
+func (m *WSMetrics) incOpen() { atomic.AddInt32(&m.OpenConns, 1) }
+func (m *WSMetrics) decOpen() { atomic.AddInt32(&m.OpenConns, -1) }
+
+func (m *WSMetrics) recordFrame(payloadLen int) {
+	atomic.AddInt64(&m.Frames, 1)
+	atomic.AddInt64(&m.Bytes, int64(payloadLen))
+}
+
+func (m *WSMetrics) recordClose(payload []byte) {
+	code := 1005 // RFC 6455 "No Status Rcvd"
+	if len(payload) >= 2 {
+		code = int(binary.BigEndian.Uint16(payload[:2]))
+	}
+	m.closeCodes.mu.Lock()
+	m.closeCodes.m[code]++
+	m.closeCodes.mu.Unlock()
+}
+
+// wsMetricsSnapshot is the JSON shape served at /-/ws-metrics.
+type wsMetricsSnapshot struct {
+	Frames     int64         `json:"frames"`
+	Bytes      int64         `json:"bytes"`
+	OpenConns  int32         `json:"open_conns"`
+	CloseCodes map[int]int64 `json:"close_codes"`
+}
+
+func serveWSMetrics(w http.ResponseWriter, r *http.Request) {
+	wsMetrics.closeCodes.mu.Lock()
+	codes := make(map[int]int64, len(wsMetrics.closeCodes.m))
+	for k, v := range wsMetrics.closeCodes.m {
+		codes[k] = v
+	}
+	wsMetrics.closeCodes.mu.Unlock()
+
+	snap := wsMetricsSnapshot{
+		Frames:     atomic.LoadInt64(&wsMetrics.Frames),
+		Bytes:      atomic.LoadInt64(&wsMetrics.Bytes),
+		OpenConns:  atomic.LoadInt32(&wsMetrics.OpenConns),
+		CloseCodes: codes,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(snap)
+}
+
+// wsFrame is a single parsed WebSocket frame. Payload is always the
+// unmasked application data; MaskKey is retained so a masked frame can be
+// re-masked identically when forwarded.
+type wsFrame struct {
+	Fin     bool
+	Rsv     byte // RSV1-3 bits as received (RSV1 marks permessage-deflate)
+	Opcode  byte
+	Masked  bool
+	MaskKey [4]byte
+	Payload []byte
+}
+
+// readWSFrame reads one frame from r, per RFC 6455 section 5.2.
+func readWSFrame(r *bufio.Reader) (*wsFrame, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	f := &wsFrame{
+		Fin:    hdr[0]&0x80 != 0,
+		Rsv:    hdr[0] & 0x70,
+		Opcode: hdr[0] & 0x0f,
+		Masked: hdr[1]&0x80 != 0,
+	}
+
+	length := int64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext))
+	}
+
+	if length > maxWSFrameLength {
+		return nil, fmt.Errorf("websocket: frame length %d exceeds max %d", length, maxWSFrameLength)
+	}
+
+	if f.Masked {
+		if _, err := io.ReadFull(r, f.MaskKey[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	if f.Masked {
+		for i := range f.Payload {
+			f.Payload[i] ^= f.MaskKey[i%4]
+		}
+	}
+	return f, nil
+}
+
+// writeWSFrame serializes f to w, re-masking the payload with its original
+// mask key if it was masked on read, so masked frames are forwarded
+// byte-for-byte identical to how they arrived.
+func writeWSFrame(w io.Writer, f *wsFrame) error {
+	b0 := f.Opcode | f.Rsv
+	if f.Fin {
+		b0 |= 0x80
+	}
+
+	var b1 byte
+	if f.Masked {
+		b1 |= 0x80
+	}
+
+	var hdr []byte
+	length := len(f.Payload)
+	switch {
+	case length <= 125:
+		hdr = []byte{b0, b1 | byte(length)}
+	case length <= 0xffff:
+		hdr = make([]byte, 4)
+		hdr[0], hdr[1] = b0, b1|126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(length))
+	default:
+		hdr = make([]byte, 10)
+		hdr[0], hdr[1] = b0, b1|127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(length))
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	payload := f.Payload
+	if f.Masked {
+		if _, err := w.Write(f.MaskKey[:]); err != nil {
+			return err
+		}
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ f.MaskKey[i%4]
+		}
+		payload = masked
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// pumpWSFrames reads frames from src and forwards each one, verbatim, to
+// dst, reassembling text/binary messages across continuation frames and
+// invoking onMessage once per complete message. It returns when src is
+// closed, a close frame is forwarded, or an error occurs.
+func pumpWSFrames(src *bufio.Reader, dst io.Writer, onMessage func(msgType int, payload []byte)) error {
+	var msgOpcode byte
+	var msgBuf []byte
+
+	for {
+		f, err := readWSFrame(src)
+		if err != nil {
+			return err
+		}
+
+		switch f.Opcode {
+		case wsOpContinuation:
+			if onMessage != nil {
+				msgBuf = append(msgBuf, f.Payload...)
+			}
+		case wsOpText, wsOpBinary:
+			msgOpcode = f.Opcode
+			if onMessage != nil {
+				msgBuf = append([]byte(nil), f.Payload...)
+			}
+		case wsOpClose:
+			wsMetrics.recordClose(f.Payload)
+		}
+
+		if onMessage != nil && len(msgBuf) > maxWSMessageLength {
+			return fmt.Errorf("websocket: reassembled message exceeds max %d bytes", maxWSMessageLength)
+		}
+
+		wsMetrics.recordFrame(len(f.Payload))
+
+		if err := writeWSFrame(dst, f); err != nil {
+			return err
+		}
+
+		if f.Fin && (f.Opcode == wsOpText || f.Opcode == wsOpBinary || f.Opcode == wsOpContinuation) {
+			if onMessage != nil && (msgOpcode == wsOpText || msgOpcode == wsOpBinary) {
+				onMessage(int(msgOpcode), msgBuf)
+			}
+			msgBuf = nil
+		}
+
+		if f.Opcode == wsOpClose {
+			return nil
+		}
+	}
+}
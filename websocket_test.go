@@ -0,0 +1,133 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestReadWSFrameLength(t *testing.T) {
+	cases := []struct {
+		name    string
+		frame   func() []byte
+		wantErr bool
+	}{
+		{
+			name: "small unmasked payload",
+			frame: func() []byte {
+				return []byte{0x82, 0x02, 'h', 'i'} // FIN+binary, len=2
+			},
+		},
+		{
+			name: "16-bit extended length within bound",
+			frame: func() []byte {
+				var b bytes.Buffer
+				b.WriteByte(0x82)
+				b.WriteByte(0x7e) // len=126 marker -> 2-byte extended length
+				var ext [2]byte
+				binary.BigEndian.PutUint16(ext[:], 4)
+				b.Write(ext[:])
+				b.WriteString("data")
+				return b.Bytes()
+			},
+		},
+		{
+			name: "64-bit extended length exceeds max frame size",
+			frame: func() []byte {
+				var b bytes.Buffer
+				b.WriteByte(0x82)
+				b.WriteByte(0x7f) // len=127 marker -> 8-byte extended length
+				var ext [8]byte
+				binary.BigEndian.PutUint64(ext[:], 1<<40)
+				b.Write(ext[:])
+				return b.Bytes()
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readWSFrame(bufio.NewReader(bytes.NewReader(tc.frame())))
+			if tc.wantErr && err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// buildWSFrame encodes a single unmasked frame using an 8-byte extended
+// length, so tests can assemble arbitrarily large payloads without worrying
+// about the 7-bit/16-bit length encodings.
+func buildWSFrame(fin bool, opcode byte, payload []byte) []byte {
+	var b bytes.Buffer
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	b.WriteByte(b0)
+	b.WriteByte(0x7f)
+	var ext [8]byte
+	binary.BigEndian.PutUint64(ext[:], uint64(len(payload)))
+	b.Write(ext[:])
+	b.Write(payload)
+	return b.Bytes()
+}
+
+// TestPumpWSFramesCapsReassembledMessageSize guards against the bug where
+// msgBuf grew across continuation frames with no bound on the total
+// reassembled size: readWSFrame's maxWSFrameLength only caps one frame's
+// allocation, so an endpoint delaying FIN across enough continuation frames
+// could still grow msgBuf without limit. A message that crosses
+// maxWSMessageLength must now abort instead of growing forever.
+func TestPumpWSFramesCapsReassembledMessageSize(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(buildWSFrame(false, wsOpText, make([]byte, 1)))
+	wire.Write(buildWSFrame(false, wsOpContinuation, make([]byte, maxWSFrameLength)))
+	wire.Write(buildWSFrame(false, wsOpContinuation, make([]byte, maxWSFrameLength)))
+
+	src := bufio.NewReader(&wire)
+	err := pumpWSFrames(src, io.Discard, func(msgType int, payload []byte) {})
+	if err == nil {
+		t.Fatal("expected error once reassembled message exceeds maxWSMessageLength, got nil")
+	}
+}
+
+// TestPumpWSFramesSkipsBufferingWithoutHook ensures that when onMessage is
+// nil (the default — no hook installed), pumpWSFrames never buffers
+// continuation payloads at all, so a message past maxWSMessageLength
+// still forwards cleanly instead of erroring.
+func TestPumpWSFramesSkipsBufferingWithoutHook(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(buildWSFrame(false, wsOpText, make([]byte, 1)))
+	wire.Write(buildWSFrame(false, wsOpContinuation, make([]byte, maxWSFrameLength)))
+	wire.Write(buildWSFrame(true, wsOpContinuation, make([]byte, maxWSFrameLength)))
+	wire.Write(buildWSFrame(true, wsOpClose, nil))
+
+	src := bufio.NewReader(&wire)
+	if err := pumpWSFrames(src, io.Discard, nil); err != nil {
+		t.Fatalf("unexpected error with no onMessage hook installed: %v", err)
+	}
+}